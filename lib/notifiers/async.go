@@ -0,0 +1,253 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// Default AsyncDelivery tunables, used when the delivery config omits the
+// corresponding field.
+const (
+	DefaultQueueSize   = 256
+	DefaultWorkerCount = 4
+)
+
+// Backpressure policies for a full queue.
+const (
+	BackpressureBlock      = "block"
+	BackpressureDropOldest = "dropOldest"
+)
+
+// QueuedEvent is a single build event awaiting delivery.
+type QueuedEvent struct {
+	Build   *cbpb.Build
+	Payload []byte
+}
+
+// AsyncDelivery fans a stream of QueuedEvents out to a fixed pool of worker
+// goroutines, optionally coalescing events that arrive within a
+// FlushInterval of one another into a single batch. Notifiers that want
+// asynchronous, non-blocking SendNotification calls construct one with
+// NewAsyncDelivery and Enqueue onto it instead of delivering inline.
+type AsyncDelivery struct {
+	WorkerCount   int
+	FlushInterval time.Duration
+	Batch         bool
+	Backpressure  string
+	Deliver       func(ctx context.Context, events []QueuedEvent) error
+	Metrics       *Metrics
+
+	queue chan QueuedEvent
+	wg    sync.WaitGroup
+}
+
+// NewAsyncDelivery builds an AsyncDelivery from the `workerCount`,
+// `queueSize`, `flushInterval`, `batch`, and `backpressure` fields of a
+// delivery config. deliver is called with one event per call unless batch is
+// requested, in which case it may be called with several events that
+// arrived within flushInterval of each other.
+func NewAsyncDelivery(delivery map[string]interface{}, metrics *Metrics, deliver func(ctx context.Context, events []QueuedEvent) error) (*AsyncDelivery, error) {
+	a := &AsyncDelivery{
+		WorkerCount:  DefaultWorkerCount,
+		Backpressure: BackpressureBlock,
+		Deliver:      deliver,
+		Metrics:      metrics,
+	}
+
+	queueSize := DefaultQueueSize
+	if v, ok := delivery["queueSize"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `queueSize` to be a number, got %v", v)
+		}
+		queueSize = int(f)
+	}
+	a.queue = make(chan QueuedEvent, queueSize)
+
+	if v, ok := delivery["workerCount"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `workerCount` to be a number, got %v", v)
+		}
+		a.WorkerCount = int(f)
+	}
+
+	if v, ok := delivery["flushInterval"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `flushInterval` to be a duration string, got %v", v)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse delivery config field `flushInterval`: %w", err)
+		}
+		a.FlushInterval = d
+	}
+
+	if v, ok := delivery["batch"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `batch` to be a bool, got %v", v)
+		}
+		a.Batch = b
+	}
+
+	if v, ok := delivery["backpressure"]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `backpressure` to be a string, got %v", v)
+		}
+		switch s {
+		case BackpressureBlock, BackpressureDropOldest:
+			a.Backpressure = s
+		default:
+			return nil, fmt.Errorf("unknown `backpressure` %q, expected one of block, dropOldest", s)
+		}
+	}
+
+	return a, nil
+}
+
+// Start launches the worker pool. It must be called once before Enqueue.
+func (a *AsyncDelivery) Start(ctx context.Context) {
+	for i := 0; i < a.WorkerCount; i++ {
+		a.wg.Add(1)
+		go a.worker(ctx)
+	}
+}
+
+// Enqueue adds an event to the queue, applying the configured backpressure
+// policy if the queue is full: block waits for room, dropOldest discards the
+// longest-waiting queued event to make room for the new one.
+func (a *AsyncDelivery) Enqueue(ctx context.Context, ev QueuedEvent) error {
+	if a.Metrics != nil {
+		a.Metrics.SetQueueDepth(len(a.queue))
+	}
+
+	if a.Backpressure == BackpressureDropOldest {
+		select {
+		case a.queue <- ev:
+		default:
+			select {
+			case dropped := <-a.queue:
+				log.Warningf("dropping oldest queued event (build id = %s) to make room", dropped.Build.Id)
+				if a.Metrics != nil {
+					a.Metrics.IncDropped()
+				}
+			default:
+			}
+			select {
+			case a.queue <- ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	select {
+	case a.queue <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown closes the queue and blocks until all workers have drained it and
+// delivered any remaining events, or ctx expires first.
+func (a *AsyncDelivery) Shutdown(ctx context.Context) error {
+	close(a.queue)
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out draining delivery queue: %w", ctx.Err())
+	}
+}
+
+func (a *AsyncDelivery) worker(ctx context.Context) {
+	defer a.wg.Done()
+
+	if !a.Batch {
+		for ev := range a.queue {
+			a.deliverOne(ctx, ev)
+		}
+		return
+	}
+
+	var batch []QueuedEvent
+	var timer *time.Timer
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		a.deliverBatch(ctx, batch)
+		batch = nil
+	}
+
+	for {
+		var timerC <-chan time.Time
+		if timer != nil {
+			timerC = timer.C
+		}
+		select {
+		case ev, ok := <-a.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, ev)
+			if timer == nil {
+				timer = time.NewTimer(a.FlushInterval)
+			}
+		case <-timerC:
+			timer = nil
+			flush()
+		}
+	}
+}
+
+func (a *AsyncDelivery) deliverOne(ctx context.Context, ev QueuedEvent) {
+	a.deliverBatch(ctx, []QueuedEvent{ev})
+}
+
+func (a *AsyncDelivery) deliverBatch(ctx context.Context, events []QueuedEvent) {
+	if a.Metrics != nil {
+		a.Metrics.AddInFlight(len(events))
+		defer a.Metrics.AddInFlight(-len(events))
+	}
+	if err := a.Deliver(ctx, events); err != nil {
+		log.Errorf("failed to deliver batch of %d event(s): %v", len(events), err)
+		if a.Metrics != nil {
+			a.Metrics.IncFailure(len(events))
+		}
+		return
+	}
+	if a.Metrics != nil {
+		a.Metrics.IncSuccess(len(events))
+	}
+}
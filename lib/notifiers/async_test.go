@@ -0,0 +1,169 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func drainAsyncDelivery(t *testing.T, a *AsyncDelivery) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() returned error: %v", err)
+	}
+}
+
+func TestAsyncDeliveryDeliversOneEventPerCall(t *testing.T) {
+	var mu sync.Mutex
+	var delivered [][]QueuedEvent
+	deliver := func(ctx context.Context, events []QueuedEvent) error {
+		mu.Lock()
+		delivered = append(delivered, events)
+		mu.Unlock()
+		return nil
+	}
+
+	a, err := NewAsyncDelivery(map[string]interface{}{"workerCount": float64(1)}, nil, deliver)
+	if err != nil {
+		t.Fatalf("NewAsyncDelivery() returned error: %v", err)
+	}
+	a.Start(context.Background())
+
+	for i := 0; i < 3; i++ {
+		ev := QueuedEvent{Build: &cbpb.Build{Id: fmt.Sprintf("b%d", i)}}
+		if err := a.Enqueue(context.Background(), ev); err != nil {
+			t.Fatalf("Enqueue() returned error: %v", err)
+		}
+	}
+	drainAsyncDelivery(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 3 {
+		t.Fatalf("got %d delivery calls, want 3", len(delivered))
+	}
+	for _, events := range delivered {
+		if len(events) != 1 {
+			t.Errorf("delivery call had %d events, want 1 (batching disabled)", len(events))
+		}
+	}
+}
+
+func TestAsyncDeliveryBatchesWithinFlushInterval(t *testing.T) {
+	var mu sync.Mutex
+	var delivered [][]QueuedEvent
+	deliver := func(ctx context.Context, events []QueuedEvent) error {
+		mu.Lock()
+		delivered = append(delivered, events)
+		mu.Unlock()
+		return nil
+	}
+
+	a, err := NewAsyncDelivery(map[string]interface{}{
+		"workerCount":   float64(1),
+		"batch":         true,
+		"flushInterval": "50ms",
+	}, nil, deliver)
+	if err != nil {
+		t.Fatalf("NewAsyncDelivery() returned error: %v", err)
+	}
+	a.Start(context.Background())
+
+	for i := 0; i < 3; i++ {
+		ev := QueuedEvent{Build: &cbpb.Build{Id: fmt.Sprintf("b%d", i)}}
+		if err := a.Enqueue(context.Background(), ev); err != nil {
+			t.Fatalf("Enqueue() returned error: %v", err)
+		}
+	}
+	drainAsyncDelivery(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 1 {
+		t.Fatalf("got %d delivery calls, want 1 (events should coalesce into a single batch)", len(delivered))
+	}
+	if len(delivered[0]) != 3 {
+		t.Errorf("batch had %d events, want 3", len(delivered[0]))
+	}
+}
+
+// TestAsyncDeliveryDropOldestBackpressure fills a single-slot queue while its
+// one worker is blocked mid-delivery, then verifies that enqueuing past
+// capacity under the dropOldest policy discards the longest-waiting queued
+// event rather than the new one.
+func TestAsyncDeliveryDropOldestBackpressure(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var delivered []string
+	deliver := func(ctx context.Context, events []QueuedEvent) error {
+		select {
+		case started <- struct{}{}:
+			<-release // block the only worker so the queue fills up behind it
+		default:
+		}
+		mu.Lock()
+		for _, ev := range events {
+			delivered = append(delivered, ev.Build.Id)
+		}
+		mu.Unlock()
+		return nil
+	}
+
+	a, err := NewAsyncDelivery(map[string]interface{}{
+		"workerCount":  float64(1),
+		"queueSize":    float64(1),
+		"backpressure": "dropOldest",
+	}, nil, deliver)
+	if err != nil {
+		t.Fatalf("NewAsyncDelivery() returned error: %v", err)
+	}
+	a.Start(context.Background())
+
+	if err := a.Enqueue(context.Background(), QueuedEvent{Build: &cbpb.Build{Id: "first"}}); err != nil {
+		t.Fatalf("Enqueue() returned error: %v", err)
+	}
+	<-started // wait for the worker to dequeue "first" and block on it
+
+	if err := a.Enqueue(context.Background(), QueuedEvent{Build: &cbpb.Build{Id: "second"}}); err != nil {
+		t.Fatalf("Enqueue() returned error: %v", err)
+	}
+	if err := a.Enqueue(context.Background(), QueuedEvent{Build: &cbpb.Build{Id: "third"}}); err != nil {
+		t.Fatalf("Enqueue() returned error: %v", err)
+	}
+
+	close(release)
+	drainAsyncDelivery(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"first", "third"}
+	if len(delivered) != len(want) {
+		t.Fatalf("delivered %v, want %v", delivered, want)
+	}
+	for i, id := range want {
+		if delivered[i] != id {
+			t.Errorf("delivered[%d] = %q, want %q", i, delivered[i], id)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+)
+
+// NewDeadLetterSinkFromConfig builds the DeadLetterSink named by a delivery
+// config's `deadLetter` block, e.g. `{"pubsubTopic": "projects/p/topics/t"}`
+// or `{"gcsPath": "gs://bucket/prefix"}`. It returns a nil sink (and nil
+// error) if the delivery config has no `deadLetter` field.
+func NewDeadLetterSinkFromConfig(ctx context.Context, delivery map[string]interface{}) (DeadLetterSink, error) {
+	dl, ok := delivery["deadLetter"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if topic, ok := dl["pubsubTopic"].(string); ok {
+		return NewPubSubDeadLetterSink(ctx, topic)
+	}
+	if path, ok := dl["gcsPath"].(string); ok {
+		return NewGCSDeadLetterSink(ctx, path)
+	}
+	return nil, fmt.Errorf("expected delivery config field `deadLetter` to have either `pubsubTopic` or `gcsPath`, got %v", dl)
+}
+
+// pubsubDeadLetterSink publishes DeadLetterEvents as JSON messages to a
+// Pub/Sub topic.
+type pubsubDeadLetterSink struct {
+	topic *pubsub.Topic
+}
+
+// NewPubSubDeadLetterSink returns a DeadLetterSink that publishes to the
+// given fully-qualified topic name (`projects/{project}/topics/{topic}`).
+func NewPubSubDeadLetterSink(ctx context.Context, topicName string) (DeadLetterSink, error) {
+	parts := strings.Split(topicName, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return nil, fmt.Errorf("expected dead-letter topic in the form `projects/{project}/topics/{topic}`, got %q", topicName)
+	}
+	client, err := pubsub.NewClient(ctx, parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	return &pubsubDeadLetterSink{topic: client.Topic(parts[3])}, nil
+}
+
+func (s *pubsubDeadLetterSink) Send(ctx context.Context, event *DeadLetterEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter event: %w", err)
+	}
+	result := s.topic.Publish(ctx, &pubsub.Message{Data: data})
+	_, err = result.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to publish dead-letter event: %w", err)
+	}
+	return nil
+}
+
+// gcsDeadLetterSink writes DeadLetterEvents as JSON objects to a GCS bucket,
+// one object per event, keyed by build ID and timestamp.
+type gcsDeadLetterSink struct {
+	client *storage.Client
+	bucket string
+	prefix string
+}
+
+// NewGCSDeadLetterSink returns a DeadLetterSink that writes to the given
+// `gs://bucket/prefix` path.
+func NewGCSDeadLetterSink(ctx context.Context, gcsPath string) (DeadLetterSink, error) {
+	trimmed := strings.TrimPrefix(gcsPath, "gs://")
+	if trimmed == gcsPath {
+		return nil, fmt.Errorf("expected dead-letter GCS path to start with `gs://`, got %q", gcsPath)
+	}
+	parts := strings.SplitN(trimmed, "/", 2)
+	bucket := parts[0]
+	var prefix string
+	if len(parts) == 2 {
+		prefix = strings.TrimSuffix(parts[1], "/")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &gcsDeadLetterSink{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *gcsDeadLetterSink) Send(ctx context.Context, event *DeadLetterEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter event: %w", err)
+	}
+	name := fmt.Sprintf("%s-%d.json", event.Build.Id, time.Now().UTC().UnixNano())
+	if s.prefix != "" {
+		name = s.prefix + "/" + name
+	}
+	w := s.client.Bucket(s.bucket).Object(name).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write dead-letter object %q: %w", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finalize dead-letter object %q: %w", name, err)
+	}
+	return nil
+}
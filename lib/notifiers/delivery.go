@@ -0,0 +1,244 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// Default DeliveryPolicy tunables, used when the delivery config omits the
+// corresponding field.
+const (
+	DefaultMaxAttempts     = 5
+	DefaultInitialInterval = 1 * time.Second
+	DefaultMaxInterval     = 30 * time.Second
+	DefaultMultiplier      = 2.0
+)
+
+// AttemptRecord captures the outcome of a single delivery attempt, for
+// inclusion in a DeadLetterEvent's attempt history.
+type AttemptRecord struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DeadLetterEvent is published to a DeadLetterSink when a delivery exhausts
+// its retries or hits a terminal error.
+type DeadLetterEvent struct {
+	Build      *cbpb.Build     `json:"build"`
+	Payload    []byte          `json:"payload"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Attempts   []AttemptRecord `json:"attempts"`
+}
+
+// DeadLetterSink accepts events that a DeliveryPolicy could not deliver.
+type DeadLetterSink interface {
+	Send(ctx context.Context, event *DeadLetterEvent) error
+}
+
+// DeliveryPolicy implements retry-with-backoff and dead-lettering shared by
+// notifiers that deliver over HTTP. Construct one with NewDeliveryPolicy.
+type DeliveryPolicy struct {
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	DeadLetter      DeadLetterSink
+}
+
+// NewDeliveryPolicy builds a DeliveryPolicy from the `maxAttempts`,
+// `initialInterval`, `maxInterval`, and `multiplier` fields of a delivery
+// config, falling back to the Default* constants for any field left unset.
+// deadLetter may be nil if the caller has no dead-letter sink configured.
+func NewDeliveryPolicy(delivery map[string]interface{}, deadLetter DeadLetterSink) (*DeliveryPolicy, error) {
+	p := &DeliveryPolicy{
+		MaxAttempts:     DefaultMaxAttempts,
+		InitialInterval: DefaultInitialInterval,
+		MaxInterval:     DefaultMaxInterval,
+		Multiplier:      DefaultMultiplier,
+		DeadLetter:      deadLetter,
+	}
+
+	if v, ok := delivery["maxAttempts"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `maxAttempts` to be a number, got %v", v)
+		}
+		p.MaxAttempts = int(f)
+	}
+	if v, ok := delivery["initialInterval"]; ok {
+		d, err := parseDeliveryDuration("initialInterval", v)
+		if err != nil {
+			return nil, err
+		}
+		p.InitialInterval = d
+	}
+	if v, ok := delivery["maxInterval"]; ok {
+		d, err := parseDeliveryDuration("maxInterval", v)
+		if err != nil {
+			return nil, err
+		}
+		p.MaxInterval = d
+	}
+	if v, ok := delivery["multiplier"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `multiplier` to be a number, got %v", v)
+		}
+		p.Multiplier = f
+	}
+
+	return p, nil
+}
+
+func parseDeliveryDuration(field string, v interface{}) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected delivery config field `%s` to be a duration string, got %v", field, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse delivery config field `%s` as a duration: %w", field, err)
+	}
+	return d, nil
+}
+
+// isTerminal reports whether an HTTP status code should never be retried.
+// All 4xx codes are terminal except 408 (Request Timeout) and 429 (Too Many
+// Requests), which are transient.
+func isTerminal(statusCode int) bool {
+	if statusCode < 400 || statusCode >= 500 {
+		return false
+	}
+	return statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests
+}
+
+// backoff returns the base delay for the given zero-indexed attempt, capped
+// at MaxInterval, before full jitter is applied.
+func (p *DeliveryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt))
+	if max := float64(p.MaxInterval); d > max {
+		d = max
+	}
+	return time.Duration(d)
+}
+
+// retryAfter parses a Retry-After response header, which may be either a
+// number of seconds or an HTTP-date, returning ok=false if absent or
+// unparseable. The returned duration is clamped to a minimum of zero, since a
+// receiver may send a Retry-After in the past (or a negative second count).
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0, false
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// Send executes attempt, retrying transient failures with exponential
+// backoff and full jitter until MaxAttempts is reached or a terminal
+// response is seen. attempt is invoked with a 1-indexed attempt number and
+// must return a fresh *http.Response each call (the request body cannot be
+// reused across attempts). On exhaustion or a terminal failure, the event is
+// handed to the configured DeadLetterSink, if any, and an error naming the
+// terminal status or the number of attempts made (or ctx's error, if ctx is
+// done while waiting to retry) is returned.
+func (p *DeliveryPolicy) Send(ctx context.Context, build *cbpb.Build, payload []byte, attempt func(ctx context.Context, n int) (*http.Response, error)) error {
+	var records []AttemptRecord
+	var lastStatus int
+
+	for n := 1; n <= p.MaxAttempts; n++ {
+		resp, err := attempt(ctx, n)
+		record := AttemptRecord{Attempt: n, At: time.Now().UTC()}
+		if err != nil {
+			record.Error = err.Error()
+			records = append(records, record)
+			log.Warningf("delivery attempt %d/%d failed: %v", n, p.MaxAttempts, err)
+		} else {
+			resp.Body.Close()
+			record.StatusCode = resp.StatusCode
+			records = append(records, record)
+			lastStatus = resp.StatusCode
+
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			if isTerminal(resp.StatusCode) {
+				return p.deadLetter(ctx, build, payload, lastStatus, records,
+					fmt.Errorf("delivery failed with terminal status %d", resp.StatusCode))
+			}
+		}
+
+		if n == p.MaxAttempts {
+			break
+		}
+
+		wait := p.backoff(n - 1)
+		if err == nil {
+			if ra, ok := retryAfter(resp); ok {
+				wait = ra
+			}
+		}
+		if wait < 0 {
+			wait = 0
+		}
+		wait = time.Duration(rand.Int63n(int64(wait) + 1)) // full jitter
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return p.deadLetter(ctx, build, payload, lastStatus, records, ctx.Err())
+		}
+	}
+
+	return p.deadLetter(ctx, build, payload, lastStatus, records,
+		fmt.Errorf("delivery failed after %d attempts", p.MaxAttempts))
+}
+
+func (p *DeliveryPolicy) deadLetter(ctx context.Context, build *cbpb.Build, payload []byte, statusCode int, records []AttemptRecord, cause error) error {
+	if p.DeadLetter != nil {
+		event := &DeadLetterEvent{
+			Build:      build,
+			Payload:    payload,
+			StatusCode: statusCode,
+			Attempts:   records,
+		}
+		if err := p.DeadLetter.Send(ctx, event); err != nil {
+			log.Errorf("failed to publish dead-letter event for build %s: %v", build.Id, err)
+		}
+	}
+	return cause
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+type fakeDeadLetterSink struct {
+	events []*DeadLetterEvent
+}
+
+func (s *fakeDeadLetterSink) Send(ctx context.Context, event *DeadLetterEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func newTestPolicy(sink DeadLetterSink) *DeliveryPolicy {
+	return &DeliveryPolicy{
+		MaxAttempts:     3,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      2,
+		DeadLetter:      sink,
+	}
+}
+
+func respWithStatus(code int) *http.Response {
+	return &http.Response{StatusCode: code, Header: http.Header{}, Body: http.NoBody}
+}
+
+func TestDeliveryPolicySend(t *testing.T) {
+	tests := []struct {
+		name           string
+		statuses       []int // one per attempt; the last status repeats for any further attempts
+		wantCalls      int
+		wantErr        bool
+		wantDeadLetter bool
+	}{
+		{
+			name:      "succeeds first attempt",
+			statuses:  []int{http.StatusOK},
+			wantCalls: 1,
+		},
+		{
+			name:      "retries transient status then succeeds",
+			statuses:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			wantCalls: 3,
+		},
+		{
+			name:           "terminal status is not retried",
+			statuses:       []int{http.StatusBadRequest},
+			wantCalls:      1,
+			wantErr:        true,
+			wantDeadLetter: true,
+		},
+		{
+			name:           "exhausts retries and dead-letters",
+			statuses:       []int{http.StatusServiceUnavailable},
+			wantCalls:      3,
+			wantErr:        true,
+			wantDeadLetter: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := new(fakeDeadLetterSink)
+			p := newTestPolicy(sink)
+			calls := 0
+			err := p.Send(context.Background(), &cbpb.Build{Id: "b1"}, []byte("payload"), func(ctx context.Context, n int) (*http.Response, error) {
+				idx := calls
+				if idx >= len(tc.statuses) {
+					idx = len(tc.statuses) - 1
+				}
+				calls++
+				return respWithStatus(tc.statuses[idx]), nil
+			})
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Send() error = %v, wantErr = %v", err, tc.wantErr)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("attempt called %d times, want %d", calls, tc.wantCalls)
+			}
+			wantEvents := 0
+			if tc.wantDeadLetter {
+				wantEvents = 1
+			}
+			if len(sink.events) != wantEvents {
+				t.Errorf("got %d dead-letter events, want %d", len(sink.events), wantEvents)
+			}
+		})
+	}
+}
+
+// TestDeliveryPolicySendNegativeRetryAfterDoesNotPanic is a regression test:
+// rand.Int63n panics for n <= 0, so a receiver sending a Retry-After in the
+// past (or a negative second count) must not be allowed to produce a
+// negative backoff duration.
+func TestDeliveryPolicySendNegativeRetryAfterDoesNotPanic(t *testing.T) {
+	p := newTestPolicy(nil)
+	calls := 0
+	err := p.Send(context.Background(), &cbpb.Build{Id: "b1"}, []byte("payload"), func(ctx context.Context, n int) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			resp := respWithStatus(http.StatusTooManyRequests)
+			resp.Header.Set("Retry-After", "-5")
+			return resp, nil
+		}
+		return respWithStatus(http.StatusOK), nil
+	})
+	if err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("attempt called %d times, want 2", calls)
+	}
+}
+
+func TestRetryAfterClampsNegativeDuration(t *testing.T) {
+	resp := respWithStatus(http.StatusTooManyRequests)
+	resp.Header.Set("Retry-After", "-5")
+	d, ok := retryAfter(resp)
+	if !ok {
+		t.Fatal("retryAfter() ok = false, want true for a negative-but-present Retry-After")
+	}
+	if d < 0 {
+		t.Errorf("retryAfter() = %v, want a non-negative duration", d)
+	}
+}
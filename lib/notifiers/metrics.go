@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	log "github.com/golang/glog"
+)
+
+// Metrics tracks counters for an AsyncDelivery queue, exposed in Prometheus
+// text exposition format by ServeMetrics.
+type Metrics struct {
+	queueDepth int64
+	inFlight   int64
+	success    int64
+	failure    int64
+	dropped    int64
+}
+
+// NewMetrics returns a zeroed Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func (m *Metrics) SetQueueDepth(n int) { atomic.StoreInt64(&m.queueDepth, int64(n)) }
+func (m *Metrics) AddInFlight(n int)   { atomic.AddInt64(&m.inFlight, int64(n)) }
+func (m *Metrics) IncSuccess(n int)    { atomic.AddInt64(&m.success, int64(n)) }
+func (m *Metrics) IncFailure(n int)    { atomic.AddInt64(&m.failure, int64(n)) }
+func (m *Metrics) IncDropped()         { atomic.AddInt64(&m.dropped, 1) }
+
+// ServeHTTP implements http.Handler, writing the current counters in
+// Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "notifier_queue_depth %d\n", atomic.LoadInt64(&m.queueDepth))
+	fmt.Fprintf(w, "notifier_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+	fmt.Fprintf(w, "notifier_delivery_success_total %d\n", atomic.LoadInt64(&m.success))
+	fmt.Fprintf(w, "notifier_delivery_failure_total %d\n", atomic.LoadInt64(&m.failure))
+	fmt.Fprintf(w, "notifier_delivery_dropped_total %d\n", atomic.LoadInt64(&m.dropped))
+}
+
+// ServeMetrics starts an HTTP server exposing m at /metrics on addr. It
+// returns immediately; the server runs until the process exits.
+func (m *Metrics) ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("metrics server failed: %v", err)
+		}
+	}()
+}
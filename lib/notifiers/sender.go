@@ -0,0 +1,37 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// Sender delivers a single rendered notification payload over a transport
+// (HTTP, Pub/Sub, gRPC, NATS, ...). The CEL filter, template rendering, and
+// binding resolution that produce payload are shared across transports; only
+// the delivery mechanism varies by Sender implementation.
+type Sender interface {
+	Send(ctx context.Context, build *cbpb.Build, payload []byte) error
+}
+
+// BatchSender is implemented by Senders that can deliver several
+// QueuedEvents in a single round trip (e.g. an HTTP POST of a JSON array).
+// Callers driving an AsyncDelivery with Batch enabled should type-assert for
+// this before falling back to one Send call per event.
+type BatchSender interface {
+	SendBatch(ctx context.Context, events []QueuedEvent) error
+}
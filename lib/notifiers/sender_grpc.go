@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// GRPCSender invokes a user-specified unary method on a receiver, marshaling
+// the rendered payload into a generic google.protobuf.Struct request.
+// Invocations are retried and dead-lettered through a DeliveryPolicy, the
+// same as the http transport.
+type GRPCSender struct {
+	conn     *grpc.ClientConn
+	method   string
+	delivery *DeliveryPolicy
+}
+
+// NewGRPCSender builds a GRPCSender from a delivery config's `target`
+// (host:port) and `method` (e.g. `/my.pkg.Service/Notify`) fields.
+func NewGRPCSender(ctx context.Context, delivery map[string]interface{}) (*GRPCSender, error) {
+	target, ok := delivery["target"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `target`", delivery)
+	}
+	method, ok := delivery["method"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `method`", delivery)
+	}
+	conn, err := grpc.DialContext(ctx, target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial gRPC target %q: %w", target, err)
+	}
+
+	deadLetter, err := NewDeadLetterSinkFromConfig(ctx, delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure dead-letter sink: %w", err)
+	}
+	policy, err := NewDeliveryPolicy(delivery, deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery policy: %w", err)
+	}
+
+	return &GRPCSender{conn: conn, method: method, delivery: policy}, nil
+}
+
+// Send implements Sender. payload must be a JSON object; it is unmarshaled
+// into a google.protobuf.Struct before being sent as the request message.
+func (s *GRPCSender) Send(ctx context.Context, build *cbpb.Build, payload []byte) error {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return fmt.Errorf("failed to unmarshal payload as a JSON object for gRPC request: %w", err)
+	}
+	req, err := structpb.NewStruct(fields)
+	if err != nil {
+		return fmt.Errorf("failed to build protobuf Struct request: %w", err)
+	}
+
+	err = s.delivery.Send(ctx, build, payload, func(ctx context.Context, n int) (*http.Response, error) {
+		resp := new(structpb.Struct)
+		if err := s.conn.Invoke(ctx, s.method, req, resp); err != nil {
+			return nil, fmt.Errorf("failed to invoke %q: %w", s.method, err)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver gRPC request: %w", err)
+	}
+	return nil
+}
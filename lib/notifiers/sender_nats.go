@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// NATSSender publishes rendered payloads to a subject on a NATS cluster.
+// Publishes are retried and dead-lettered through a DeliveryPolicy, the same
+// as the http transport.
+type NATSSender struct {
+	nc       *nats.Conn
+	subject  string
+	delivery *DeliveryPolicy
+}
+
+// NewNATSSender builds a NATSSender from a delivery config's `servers`
+// (a comma-separated NATS URL list) and `subject` fields.
+func NewNATSSender(ctx context.Context, delivery map[string]interface{}) (*NATSSender, error) {
+	servers, ok := delivery["servers"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `servers`", delivery)
+	}
+	subject, ok := delivery["subject"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `subject`", delivery)
+	}
+	nc, err := nats.Connect(servers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS server(s) %q: %w", servers, err)
+	}
+
+	deadLetter, err := NewDeadLetterSinkFromConfig(ctx, delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure dead-letter sink: %w", err)
+	}
+	policy, err := NewDeliveryPolicy(delivery, deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery policy: %w", err)
+	}
+
+	return &NATSSender{nc: nc, subject: subject, delivery: policy}, nil
+}
+
+// Send implements Sender.
+func (s *NATSSender) Send(ctx context.Context, build *cbpb.Build, payload []byte) error {
+	err := s.delivery.Send(ctx, build, payload, func(ctx context.Context, n int) (*http.Response, error) {
+		if err := s.nc.Publish(s.subject, payload); err != nil {
+			return nil, fmt.Errorf("failed to publish to NATS subject %q: %w", s.subject, err)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver NATS message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// PubSubSender publishes rendered payloads to a Cloud Pub/Sub topic, with
+// buildId/status/projectId set as message attributes so subscribers can
+// filter without parsing the payload. Publishes are retried and
+// dead-lettered through a DeliveryPolicy, the same as the http transport.
+type PubSubSender struct {
+	topic    *pubsub.Topic
+	delivery *DeliveryPolicy
+}
+
+// NewPubSubSender builds a PubSubSender from a delivery config's `topic`
+// field, expected in the form `projects/{project}/topics/{topic}`.
+func NewPubSubSender(ctx context.Context, delivery map[string]interface{}) (*PubSubSender, error) {
+	topicName, ok := delivery["topic"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `topic`", delivery)
+	}
+	parts := strings.Split(topicName, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return nil, fmt.Errorf("expected delivery config field `topic` in the form `projects/{project}/topics/{topic}`, got %q", topicName)
+	}
+	client, err := pubsub.NewClient(ctx, parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+
+	deadLetter, err := NewDeadLetterSinkFromConfig(ctx, delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure dead-letter sink: %w", err)
+	}
+	policy, err := NewDeliveryPolicy(delivery, deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery policy: %w", err)
+	}
+
+	return &PubSubSender{topic: client.Topic(parts[3]), delivery: policy}, nil
+}
+
+// Send implements Sender.
+func (s *PubSubSender) Send(ctx context.Context, build *cbpb.Build, payload []byte) error {
+	err := s.delivery.Send(ctx, build, payload, func(ctx context.Context, n int) (*http.Response, error) {
+		result := s.topic.Publish(ctx, &pubsub.Message{
+			Data: payload,
+			Attributes: map[string]string{
+				"buildId":   build.Id,
+				"status":    build.Status.String(),
+				"projectId": build.ProjectId,
+			},
+		})
+		if _, err := result.Get(ctx); err != nil {
+			return nil, fmt.Errorf("failed to publish to Pub/Sub topic: %w", err)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver Pub/Sub message: %w", err)
+	}
+	return nil
+}
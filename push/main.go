@@ -0,0 +1,266 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Binary push sends build notifications as mobile push notifications
+// through a Gorush-compatible `/api/push` endpoint.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/MisoRobotics/cloud-build-notifiers/lib/notifiers"
+	log "github.com/golang/glog"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// Gorush platform identifiers, per https://github.com/appleboy/gorush.
+const (
+	platformIOS     = 1
+	platformAndroid = 2
+)
+
+var platformsByName = map[string]int{
+	"ios":     platformIOS,
+	"android": platformAndroid,
+}
+
+func main() {
+	if err := notifiers.Main(new(pushNotifier)); err != nil {
+		log.Fatalf("fatal error: %v", err)
+	}
+}
+
+// gorushNotification is one entry in a Gorush `/api/push` request body.
+type gorushNotification struct {
+	Tokens   []string `json:"tokens"`
+	Platform int      `json:"platform"`
+	Message  string   `json:"message"`
+}
+
+// gorushRequest is the body of a Gorush `/api/push` request.
+type gorushRequest struct {
+	Notifications []gorushNotification `json:"notifications"`
+}
+
+// gorushLog is one entry in a Gorush `/api/push` response's `logs` array.
+type gorushLog struct {
+	Type   string   `json:"type"`
+	Tokens []string `json:"tokens,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// gorushResponse is the body of a Gorush `/api/push` response.
+type gorushResponse struct {
+	Logs []gorushLog `json:"logs"`
+}
+
+type pushNotifier struct {
+	filter   notifiers.EventFilter
+	tmpl     *template.Template
+	br       notifiers.BindingResolver
+	tmplView *notifiers.TemplateView
+
+	url           string
+	notifications []gorushNotification
+
+	client   *http.Client
+	delivery *notifiers.DeliveryPolicy
+}
+
+func (p *pushNotifier) SetUp(ctx context.Context, cfg *notifiers.Config, messageTemplate string, _ notifiers.SecretGetter, br notifiers.BindingResolver) error {
+	prd, err := notifiers.MakeCELPredicate(cfg.Spec.Notification.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to create CELPredicate: %w", err)
+	}
+	p.filter = prd
+	p.br = br
+
+	url, ok := cfg.Spec.Notification.Delivery["url"].(string)
+	if !ok {
+		return fmt.Errorf("expected delivery config %v to have string field `url`", cfg.Spec.Notification.Delivery)
+	}
+	p.url = url
+
+	tmpl, err := template.New("push_template").Parse(messageTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+	p.tmpl = tmpl
+
+	tokensByPlatform, ok := cfg.Spec.Notification.Delivery["tokens"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected delivery config %v to have object field `tokens`", cfg.Spec.Notification.Delivery)
+	}
+	for platformName, rawTokens := range tokensByPlatform {
+		platform, ok := platformsByName[platformName]
+		if !ok {
+			return fmt.Errorf("unknown platform %q in delivery config field `tokens`, expected one of ios, android", platformName)
+		}
+		tokenList, ok := rawTokens.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected delivery config field `tokens.%s` to be a list of strings", platformName)
+		}
+		tokens := make([]string, len(tokenList))
+		for i, t := range tokenList {
+			token, ok := t.(string)
+			if !ok {
+				return fmt.Errorf("expected delivery config field `tokens.%s[%d]` to be a string, got %v", platformName, i, t)
+			}
+			tokens[i] = token
+		}
+		p.notifications = append(p.notifications, gorushNotification{Tokens: tokens, Platform: platform})
+	}
+
+	p.client = http.DefaultClient
+
+	deadLetter, err := notifiers.NewDeadLetterSinkFromConfig(ctx, cfg.Spec.Notification.Delivery)
+	if err != nil {
+		return fmt.Errorf("failed to configure dead-letter sink: %w", err)
+	}
+	policy, err := notifiers.NewDeliveryPolicy(cfg.Spec.Notification.Delivery, deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to configure delivery policy: %w", err)
+	}
+	p.delivery = policy
+
+	return nil
+}
+
+func (p *pushNotifier) SendNotification(ctx context.Context, build *cbpb.Build) error {
+	if !p.filter.Apply(ctx, build) {
+		log.V(2).Infof("not sending push notification for event (build id = %s, status = %v)", build.Id, build.Status)
+		return nil
+	}
+
+	log.Infof("sending push notification for event (build id = %s, status = %s)", build.Id, build.Status)
+
+	bindings, err := p.br.Resolve(ctx, nil, build)
+	if err != nil {
+		return fmt.Errorf("failed to resolve bindings: %w", err)
+	}
+	p.tmplView = &notifiers.TemplateView{
+		Build:  &notifiers.BuildView{Build: build},
+		Params: bindings,
+	}
+
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, p.tmplView); err != nil {
+		return err
+	}
+	message := buf.String()
+
+	notifications := make([]gorushNotification, len(p.notifications))
+	for i, n := range p.notifications {
+		notifications[i] = gorushNotification{Tokens: n.Tokens, Platform: n.Platform, Message: message}
+	}
+	body, err := json.Marshal(gorushRequest{Notifications: notifications})
+	if err != nil {
+		return fmt.Errorf("failed to marshal Gorush request: %w", err)
+	}
+
+	if err := p.delivery.Send(ctx, build, body, p.pushAttempt(notifications)); err != nil {
+		return fmt.Errorf("failed to deliver push notification: %w", err)
+	}
+
+	log.V(2).Infoln("sent push notification successfully")
+	return nil
+}
+
+// pushAttempt returns a DeliveryPolicy attempt function that POSTs the
+// pending notifications to the Gorush endpoint and inspects the response's
+// `logs[]` array: any `failed-push` entry is treated as a delivery failure
+// even though Gorush itself answered 200 OK, by reporting the attempt as a
+// 502 so the caller's retry/dead-letter machinery takes over. On a
+// `failed-push`, the set of pending notifications is narrowed to just the
+// tokens that failed, so a retry does not re-push to tokens that already
+// received the notification.
+func (p *pushNotifier) pushAttempt(notifications []gorushNotification) func(ctx context.Context, n int) (*http.Response, error) {
+	pending := notifications
+	return func(ctx context.Context, n int) (*http.Response, error) {
+		body, err := json.Marshal(gorushRequest{Notifications: pending})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal Gorush request: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "GCB-Notifier/0.1 (push)")
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Gorush response: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return resp, nil
+		}
+
+		var gr gorushResponse
+		if err := json.Unmarshal(respBody, &gr); err != nil {
+			return nil, fmt.Errorf("failed to parse Gorush response: %w", err)
+		}
+		failedTokens := make(map[string]bool)
+		for _, l := range gr.Logs {
+			if l.Type == "failed-push" {
+				log.Warningf("push failed for tokens %v: %s", l.Tokens, l.Error)
+				for _, t := range l.Tokens {
+					failedTokens[t] = true
+				}
+			}
+		}
+		if len(failedTokens) == 0 {
+			return resp, nil
+		}
+		if narrowed := narrowToTokens(pending, failedTokens); len(narrowed) > 0 {
+			pending = narrowed
+		}
+		resp.StatusCode = http.StatusBadGateway
+		return resp, nil
+	}
+}
+
+// narrowToTokens returns the subset of notifications containing only tokens
+// present in tokens, preserving each notification's platform and message and
+// dropping any notification left with no tokens.
+func narrowToTokens(notifications []gorushNotification, tokens map[string]bool) []gorushNotification {
+	var narrowed []gorushNotification
+	for _, n := range notifications {
+		var kept []string
+		for _, t := range n.Tokens {
+			if tokens[t] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		narrowed = append(narrowed, gorushNotification{Tokens: kept, Platform: n.Platform, Message: n.Message})
+	}
+	return narrowed
+}
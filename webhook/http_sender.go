@@ -0,0 +1,470 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MisoRobotics/cloud-build-notifiers/lib/notifiers"
+	log "github.com/golang/glog"
+	"github.com/google/uuid"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// defaultSignatureHeader is the header the HMAC signature is attached to
+// when the delivery config does not override it via `signatureHeader`.
+const defaultSignatureHeader = "X-CloudBuild-Signature"
+
+// defaultSignaturePrefix is prepended to the hex-encoded HMAC digest, e.g.
+// "sha256=abcd...".
+const defaultSignaturePrefix = "sha256="
+
+// Supported values for the `payloadFormat` delivery config field.
+const (
+	payloadFormatRaw        = "raw"
+	payloadFormatJSON       = "json"
+	payloadFormatCloudEvent = "cloudevent"
+)
+
+// Supported values for the `cloudEventMode` delivery config field. Only
+// consulted when `payloadFormat` is `cloudevent`.
+const (
+	cloudEventModeBinary     = "binary"
+	cloudEventModeStructured = "structured"
+)
+
+const cloudEventSpecVersion = "1.0"
+
+// Supported values for the `bodyMode` delivery config field. Only consulted
+// when payloadFormat is not "cloudevent".
+const (
+	bodyModeRaw        = "raw"
+	bodyModeJSONString = "jsonString"
+	bodyModeJSONObject = "jsonObject"
+)
+
+// defaultContentType is used for every bodyMode when the delivery config
+// does not set `contentType`.
+const defaultContentType = "application/json"
+
+// cloudEvent is the structured-mode JSON envelope described by the
+// CloudEvents 1.0 spec: https://github.com/cloudevents/spec.
+type cloudEvent struct {
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// httpSender is the `http` transport: it POSTs the rendered payload to a
+// configured URL, optionally wrapped in a CloudEvents envelope, signed with
+// an HMAC, and/or delivered over mTLS. It implements notifiers.Sender and
+// notifiers.BatchSender.
+type httpSender struct {
+	url      string
+	delivery *notifiers.DeliveryPolicy
+	client   *http.Client
+
+	// payloadFormat controls how the rendered template is encoded onto the
+	// wire: raw bytes ("raw"), a re-marshaled JSON object ("json", which
+	// selects bodyMode "jsonObject" unless bodyMode is set), or a
+	// CloudEvents 1.0 envelope ("cloudevent").
+	payloadFormat string
+	// cloudEventMode selects binary (headers) or structured (JSON envelope)
+	// content mode when payloadFormat is "cloudevent".
+	cloudEventMode string
+	// ceSource is the CloudEvents `source` attribute, e.g.
+	// //cloudbuild.googleapis.com/projects/{projectId}.
+	ceSource string
+	// ceType is the CloudEvents `type` attribute.
+	ceType string
+
+	// bodyMode controls how the rendered template is encoded onto the wire
+	// when payloadFormat is not "cloudevent": raw bytes, a JSON string
+	// literal, or a re-marshaled JSON object.
+	bodyMode string
+	// contentType is the Content-Type header sent with every bodyMode.
+	contentType string
+
+	// secret, when non-empty, is used to compute an HMAC-SHA256 signature of
+	// the outgoing request body.
+	secret          string
+	signatureHeader string
+	signaturePrefix string
+	// timestampHeader, when set, is both included in the signed payload and
+	// set as a request header to let receivers reject stale/replayed
+	// requests.
+	timestampHeader string
+}
+
+// newHTTPSender builds an httpSender from the `http` transport's delivery
+// config fields.
+func newHTTPSender(ctx context.Context, delivery map[string]interface{}, sg notifiers.SecretGetter) (*httpSender, error) {
+	s := new(httpSender)
+
+	url, ok := delivery["url"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected delivery config %v to have string field `url`", delivery)
+	}
+	s.url = url
+
+	s.payloadFormat = payloadFormatRaw
+	if pf, ok := delivery["payloadFormat"]; ok {
+		pfs, ok := pf.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `payloadFormat` to be a string, got %v", pf)
+		}
+		switch pfs {
+		case payloadFormatRaw, payloadFormatJSON, payloadFormatCloudEvent:
+			s.payloadFormat = pfs
+		default:
+			return nil, fmt.Errorf("unknown `payloadFormat` %q, expected one of raw, json, cloudevent", pfs)
+		}
+	}
+
+	if s.payloadFormat == payloadFormatCloudEvent {
+		s.cloudEventMode = cloudEventModeBinary
+		if cem, ok := delivery["cloudEventMode"]; ok {
+			cems, ok := cem.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected delivery config field `cloudEventMode` to be a string, got %v", cem)
+			}
+			switch cems {
+			case cloudEventModeBinary, cloudEventModeStructured:
+				s.cloudEventMode = cems
+			default:
+				return nil, fmt.Errorf("unknown `cloudEventMode` %q, expected one of binary, structured", cems)
+			}
+		}
+
+		ceSource, ok := delivery["ceSource"].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config %v to have string field `ceSource` when payloadFormat is cloudevent", delivery)
+		}
+		s.ceSource = ceSource
+
+		s.ceType = "com.google.cloud.cloudbuild.build.v1.statusChanged"
+		if ceType, ok := delivery["ceType"]; ok {
+			ceTypeStr, ok := ceType.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected delivery config field `ceType` to be a string, got %v", ceType)
+			}
+			s.ceType = ceTypeStr
+		}
+	}
+
+	s.bodyMode = bodyModeRaw
+	if s.payloadFormat == payloadFormatJSON {
+		s.bodyMode = bodyModeJSONObject
+	}
+	if bm, ok := delivery["bodyMode"]; ok {
+		bms, ok := bm.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `bodyMode` to be a string, got %v", bm)
+		}
+		switch bms {
+		case bodyModeRaw, bodyModeJSONString, bodyModeJSONObject:
+			s.bodyMode = bms
+		default:
+			return nil, fmt.Errorf("unknown `bodyMode` %q, expected one of raw, jsonString, jsonObject", bms)
+		}
+	}
+
+	s.contentType = defaultContentType
+	if ct, ok := delivery["contentType"]; ok {
+		cts, ok := ct.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `contentType` to be a string, got %v", ct)
+		}
+		s.contentType = cts
+	}
+
+	if secretRef, ok := delivery["secretRef"]; ok {
+		secretRefStr, ok := secretRef.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `secretRef` to be a string, got %v", secretRef)
+		}
+		secret, err := sg.GetSecret(ctx, secretRefStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %q: %w", secretRefStr, err)
+		}
+		s.secret = secret
+
+		s.signatureHeader = defaultSignatureHeader
+		if sh, ok := delivery["signatureHeader"]; ok {
+			shs, ok := sh.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected delivery config field `signatureHeader` to be a string, got %v", sh)
+			}
+			s.signatureHeader = shs
+		}
+
+		s.signaturePrefix = defaultSignaturePrefix
+		if sp, ok := delivery["signaturePrefix"]; ok {
+			sps, ok := sp.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected delivery config field `signaturePrefix` to be a string, got %v", sp)
+			}
+			s.signaturePrefix = sps
+		}
+	}
+
+	if th, ok := delivery["timestampHeader"]; ok {
+		ths, ok := th.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected delivery config field `timestampHeader` to be a string, got %v", th)
+		}
+		s.timestampHeader = ths
+	}
+
+	client := http.DefaultClient
+	certPath, hasCert := delivery["clientCert"].(string)
+	keyPath, hasKey := delivery["clientKey"].(string)
+	if hasCert != hasKey {
+		return nil, fmt.Errorf("delivery config fields `clientCert` and `clientKey` must be set together")
+	}
+	if hasCert && hasKey {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair: %w", err)
+		}
+		tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		if caBundlePath, ok := delivery["caBundle"].(string); ok {
+			caCert, err := os.ReadFile(caBundlePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle %q: %w", caBundlePath, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("failed to parse CA bundle %q", caBundlePath)
+			}
+			tlsCfg.RootCAs = pool
+		}
+
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	}
+	s.client = client
+
+	deadLetter, err := notifiers.NewDeadLetterSinkFromConfig(ctx, delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure dead-letter sink: %w", err)
+	}
+	policy, err := notifiers.NewDeliveryPolicy(delivery, deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure delivery policy: %w", err)
+	}
+	s.delivery = policy
+
+	return s, nil
+}
+
+// Send implements notifiers.Sender.
+func (s *httpSender) Send(ctx context.Context, build *cbpb.Build, payload []byte) error {
+	body, contentType, ceHeaders, err := s.bodyForEvent(build, payload)
+	if err != nil {
+		return fmt.Errorf("failed to build payload: %w", err)
+	}
+	return s.sendRequest(ctx, build, body, contentType, ceHeaders)
+}
+
+// SendBatch implements notifiers.BatchSender, coalescing several events into
+// one JSON-array POST.
+func (s *httpSender) SendBatch(ctx context.Context, events []notifiers.QueuedEvent) error {
+	parts := make([]json.RawMessage, len(events))
+	for i, ev := range events {
+		body, contentType, _, err := s.bodyForEvent(ev.Build, ev.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to build payload: %w", err)
+		}
+		if contentType != "application/json" && contentType != "application/cloudevents+json" {
+			return fmt.Errorf("cannot batch non-JSON payload (content type %q) for build %s", contentType, ev.Build.Id)
+		}
+		parts[i] = json.RawMessage(body)
+	}
+	batched, err := json.Marshal(parts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batched payload: %w", err)
+	}
+	return s.sendRequest(ctx, events[0].Build, string(batched), "application/json", nil)
+}
+
+// bodyForEvent renders an event's raw template output into the wire body,
+// applying the configured payloadFormat and, for non-CloudEvents formats,
+// bodyMode.
+func (s *httpSender) bodyForEvent(build *cbpb.Build, raw []byte) (body, contentType string, ceHeaders map[string]string, err error) {
+	if s.payloadFormat == payloadFormatCloudEvent {
+		return s.buildCloudEvent(raw, build)
+	}
+	body, err = s.renderBody(raw)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return body, s.contentType, nil, nil
+}
+
+// renderBody encodes the raw template output per bodyMode: "raw" sends it
+// verbatim, "jsonString" wraps it as a JSON string literal, and
+// "jsonObject" parses it as JSON and re-marshals it, failing fast with the
+// offending line number if it isn't valid JSON.
+func (s *httpSender) renderBody(raw []byte) (string, error) {
+	switch s.bodyMode {
+	case bodyModeRaw:
+		return string(raw), nil
+	case bodyModeJSONString:
+		encoded, err := json.Marshal(string(raw))
+		if err != nil {
+			return "", fmt.Errorf("failed to encode template output as a JSON string: %w", err)
+		}
+		return string(encoded), nil
+	case bodyModeJSONObject:
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return "", fmt.Errorf("template output is not valid JSON for bodyMode=jsonObject: %s", jsonSyntaxError(raw, err))
+		}
+		reencoded, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("failed to re-marshal template output: %w", err)
+		}
+		return string(reencoded), nil
+	default:
+		return "", fmt.Errorf("unknown bodyMode %q", s.bodyMode)
+	}
+}
+
+// jsonSyntaxError annotates a json.Unmarshal error with the 1-indexed line
+// it occurred on, when err is a *json.SyntaxError.
+func jsonSyntaxError(data []byte, err error) string {
+	serr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err.Error()
+	}
+	line := 1 + bytes.Count(data[:serr.Offset], []byte("\n"))
+	return fmt.Sprintf("line %d: %v", line, err)
+}
+
+// sendRequest POSTs body to s.url, retrying and dead-lettering through
+// s.delivery, attaching the CloudEvents headers (if any), a replay-resistant
+// timestamp, and an HMAC signature (if configured).
+func (s *httpSender) sendRequest(ctx context.Context, build *cbpb.Build, body, contentType string, ceHeaders map[string]string) error {
+	err := s.delivery.Send(ctx, build, []byte(body), func(ctx context.Context, n int) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a new HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "GCB-Notifier/0.1 (webhook)")
+		for k, v := range ceHeaders {
+			req.Header.Set(k, v)
+		}
+
+		signedBody := body
+		if s.timestampHeader != "" {
+			ts := time.Now().UTC().Format(time.RFC3339)
+			req.Header.Set(s.timestampHeader, ts)
+			signedBody = ts + body
+		}
+		if s.secret != "" {
+			mac := hmac.New(sha256.New, []byte(s.secret))
+			mac.Write([]byte(signedBody))
+			req.Header.Set(s.signatureHeader, s.signaturePrefix+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		return s.client.Do(req)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deliver HTTP request: %w", err)
+	}
+
+	log.V(2).Infoln("sent HTTP request successfully")
+	return nil
+}
+
+// structuredCloudEventData returns the CloudEvents envelope's `data` value
+// for raw template output: the output verbatim if it's already a JSON
+// document, or the output encoded as a JSON string otherwise. Structured-mode
+// envelopes are JSON documents, so data must be valid JSON; most webhook
+// templates render plain text, not JSON, so this falls back instead of
+// assuming the caller's template output is already a JSON document.
+func structuredCloudEventData(raw []byte) (json.RawMessage, error) {
+	if json.Valid(raw) {
+		return json.RawMessage(raw), nil
+	}
+	encoded, err := json.Marshal(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template output as a JSON string: %w", err)
+	}
+	return json.RawMessage(encoded), nil
+}
+
+// buildCloudEvent wraps the rendered template `data` in a CloudEvents 1.0
+// envelope. In binary mode the envelope is expressed entirely as headers and
+// the returned body is the raw template output; in structured mode the
+// returned body is a `application/cloudevents+json` document and the
+// returned header map is empty.
+func (s *httpSender) buildCloudEvent(data []byte, build *cbpb.Build) (body, contentType string, headers map[string]string, err error) {
+	ceTime := time.Now().UTC().Format(time.RFC3339)
+
+	switch s.cloudEventMode {
+	case cloudEventModeStructured:
+		ceData, err := structuredCloudEventData(data)
+		if err != nil {
+			return "", "", nil, err
+		}
+		env := cloudEvent{
+			ID:              uuid.New().String(),
+			Source:          s.ceSource,
+			SpecVersion:     cloudEventSpecVersion,
+			Type:            s.ceType,
+			Subject:         build.Id,
+			Time:            ceTime,
+			DataContentType: "application/json",
+			Data:            ceData,
+		}
+		structured, err := json.Marshal(env)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("failed to marshal CloudEvents envelope: %w", err)
+		}
+		return string(structured), "application/cloudevents+json", nil, nil
+	case cloudEventModeBinary:
+		headers = map[string]string{
+			"Ce-Id":          uuid.New().String(),
+			"Ce-Source":      s.ceSource,
+			"Ce-Type":        s.ceType,
+			"Ce-Subject":     build.Id,
+			"Ce-Time":        ceTime,
+			"Ce-Specversion": cloudEventSpecVersion,
+		}
+		return string(data), "application/json", headers, nil
+	default:
+		return "", "", nil, fmt.Errorf("unknown cloudEventMode %q", s.cloudEventMode)
+	}
+}
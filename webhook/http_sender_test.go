@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MisoRobotics/cloud-build-notifiers/lib/notifiers"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestRenderBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodyMode string
+		raw      string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "raw",
+			bodyMode: bodyModeRaw,
+			raw:      `{"text":"hi"}`,
+			want:     `{"text":"hi"}`,
+		},
+		{
+			name:     "jsonString",
+			bodyMode: bodyModeJSONString,
+			raw:      `{"text":"hi"}`,
+			want:     `"{\"text\":\"hi\"}"`,
+		},
+		{
+			name:     "jsonObject",
+			bodyMode: bodyModeJSONObject,
+			raw:      `{"text":"hi"}`,
+			want:     `{"text":"hi"}`,
+		},
+		{
+			name:     "jsonObject invalid JSON",
+			bodyMode: bodyModeJSONObject,
+			raw:      "not json",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &httpSender{bodyMode: tc.bodyMode}
+			got, err := s.renderBody([]byte(tc.raw))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("renderBody(%q) = nil error, want an error", tc.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderBody(%q) returned error: %v", tc.raw, err)
+			}
+			if got != tc.want {
+				t.Errorf("renderBody(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSendRequestRawByteIdentical verifies that bodyMode "raw" delivers the
+// template output to the receiver byte-identical to what was rendered, with
+// no re-encoding in between.
+func TestSendRequestRawByteIdentical(t *testing.T) {
+	const want = `{"text":"hi"}`
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+		got = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	policy, err := notifiers.NewDeliveryPolicy(map[string]interface{}{"maxAttempts": float64(1)}, nil)
+	if err != nil {
+		t.Fatalf("NewDeliveryPolicy() returned error: %v", err)
+	}
+	s := &httpSender{
+		url:         srv.URL,
+		client:      http.DefaultClient,
+		delivery:    policy,
+		bodyMode:    bodyModeRaw,
+		contentType: "application/json",
+	}
+
+	build := &cbpb.Build{Id: "build-id"}
+	if err := s.Send(context.Background(), build, []byte(want)); err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("server received body %q, want byte-identical %q", got, want)
+	}
+}
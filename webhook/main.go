@@ -0,0 +1,191 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/MisoRobotics/cloud-build-notifiers/lib/notifiers"
+	log "github.com/golang/glog"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// shutdownGracePeriod bounds how long main waits for the delivery queue to
+// drain after receiving SIGTERM.
+const shutdownGracePeriod = 30 * time.Second
+
+// Supported values for the `transport` delivery config field.
+const (
+	transportHTTP      = "http"
+	transportGCPPubSub = "gcppubsub"
+	transportGRPC      = "grpc"
+	transportNATS      = "nats"
+)
+
+func main() {
+	h := new(webhookNotifier)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Infoln("received SIGTERM, draining delivery queue")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		if async := h.async.Load(); async != nil {
+			if err := async.Shutdown(ctx); err != nil {
+				log.Errorf("error draining delivery queue: %v", err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}()
+
+	if err := notifiers.Main(h); err != nil {
+		log.Fatalf("fatal error: %v", err)
+	}
+}
+
+// webhookNotifier renders build events through a shared CEL filter and
+// template, then delivers the rendered payload over a pluggable transport
+// selected by the delivery config's `transport` field.
+type webhookNotifier struct {
+	filter   notifiers.EventFilter
+	tmpl     *template.Template
+	br       notifiers.BindingResolver
+	tmplView *notifiers.TemplateView
+
+	// sender delivers one rendered payload per build event over the
+	// configured transport (http, gcppubsub, grpc, or nats).
+	sender notifiers.Sender
+
+	// async queues events for batched, asynchronous delivery by a worker
+	// pool, draining to metrics on a /metrics endpoint when configured. It is
+	// set once, by SetUp, but read concurrently by the SIGTERM handler in
+	// main, so it's stored behind an atomic.Pointer rather than assigned
+	// directly.
+	async   atomic.Pointer[notifiers.AsyncDelivery]
+	metrics *notifiers.Metrics
+}
+
+func (h *webhookNotifier) SetUp(ctx context.Context, cfg *notifiers.Config, webhookTemplate string, sg notifiers.SecretGetter, br notifiers.BindingResolver) error {
+	prd, err := notifiers.MakeCELPredicate(cfg.Spec.Notification.Filter)
+	if err != nil {
+		return fmt.Errorf("failed to create CELPredicate: %w", err)
+	}
+	h.filter = prd
+	h.br = br
+
+	tmpl, err := template.New("webhook_template").Parse(webhookTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+	h.tmpl = tmpl
+
+	delivery := cfg.Spec.Notification.Delivery
+	transport, ok := delivery["transport"].(string)
+	if !ok {
+		transport = transportHTTP
+	}
+	switch transport {
+	case transportHTTP:
+		h.sender, err = newHTTPSender(ctx, delivery, sg)
+	case transportGCPPubSub:
+		h.sender, err = notifiers.NewPubSubSender(ctx, delivery)
+	case transportGRPC:
+		h.sender, err = notifiers.NewGRPCSender(ctx, delivery)
+	case transportNATS:
+		h.sender, err = notifiers.NewNATSSender(ctx, delivery)
+	default:
+		return fmt.Errorf("unknown `transport` %q, expected one of http, gcppubsub, grpc, nats", transport)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to configure %q transport: %w", transport, err)
+	}
+
+	h.metrics = notifiers.NewMetrics()
+	if addr, ok := delivery["metricsAddr"].(string); ok {
+		h.metrics.ServeMetrics(addr)
+	}
+	async, err := notifiers.NewAsyncDelivery(delivery, h.metrics, h.deliverEvents)
+	if err != nil {
+		return fmt.Errorf("failed to configure async delivery: %w", err)
+	}
+	async.Start(context.Background())
+	h.async.Store(async)
+
+	return nil
+}
+
+func (h *webhookNotifier) SendNotification(ctx context.Context, build *cbpb.Build) error {
+	if !h.filter.Apply(ctx, build) {
+		log.V(2).Infof("not sending webhook notification for event (build id = %s, status = %v)", build.Id, build.Status)
+		return nil
+	}
+
+	log.Infof("sending webhook notification for event (build id = %s, status = %s)", build.Id, build.Status)
+
+	bindings, err := h.br.Resolve(ctx, nil, build)
+	if err != nil {
+		return fmt.Errorf("failed to resolve bindings: %w", err)
+	}
+	h.tmplView = &notifiers.TemplateView{
+		Build:  &notifiers.BuildView{Build: build},
+		Params: bindings,
+	}
+
+	logURL, err := notifiers.AddUTMParams(build.LogUrl, notifiers.HTTPMedium)
+	if err != nil {
+		return fmt.Errorf("failed to add UTM params: %w", err)
+	}
+	build.LogUrl = logURL
+
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, h.tmplView); err != nil {
+		return err
+	}
+
+	if err := h.async.Load().Enqueue(ctx, notifiers.QueuedEvent{Build: build, Payload: buf.Bytes()}); err != nil {
+		return fmt.Errorf("failed to enqueue event for delivery: %w", err)
+	}
+
+	log.V(2).Infoln("enqueued webhook notification for delivery")
+	return nil
+}
+
+// deliverEvents is the AsyncDelivery callback: it delivers a single event
+// through h.sender, or hands a batch to h.sender's SendBatch when the
+// transport supports coalescing multiple events into one round trip.
+func (h *webhookNotifier) deliverEvents(ctx context.Context, events []notifiers.QueuedEvent) error {
+	if len(events) > 1 {
+		if bs, ok := h.sender.(notifiers.BatchSender); ok {
+			return bs.SendBatch(ctx, events)
+		}
+	}
+	for _, ev := range events {
+		if err := h.sender.Send(ctx, ev.Build, ev.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}